@@ -0,0 +1,67 @@
+package maintenance
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+// DeleteUnreferencedBlobsOptions provides options for DeleteUnreferencedBlobs.
+type DeleteUnreferencedBlobsOptions struct {
+	DryRun       bool
+	NotAfterTime time.Time
+	Prefix       blob.ID
+
+	// Protectors are consulted, in order, for each blob that would otherwise be
+	// deleted; the first one to vote to retain it wins and the blob is skipped.
+	Protectors []OrphanProtector
+}
+
+// DeletedBlobStats summarizes the outcome of a DeleteUnreferencedBlobs call.
+type DeletedBlobStats struct {
+	Count     int
+	TotalSize int64
+}
+
+// DeleteUnreferencedBlobs deletes blobs that are not referenced by any index or are
+// not indexes themselves and were not modified recently, reporting progress on pr as
+// it goes (pr may be nil). Blobs retained by opt.Protectors are skipped and logged
+// with the protector's reason instead of being deleted.
+func DeleteUnreferencedBlobs(ctx context.Context, rep repo.DirectRepositoryWriter, opt DeleteUnreferencedBlobsOptions, safety SafetyParameters, pr *progressReporter) (DeletedBlobStats, error) {
+	var stats DeletedBlobStats
+
+	err := rep.BlobReader().ListBlobs(ctx, opt.Prefix, func(bm blob.Metadata) error {
+		if !opt.NotAfterTime.IsZero() && bm.Timestamp.After(opt.NotAfterTime) {
+			return nil
+		}
+
+		reason, err := protectBlob(ctx, opt.Protectors, bm.BlobID)
+		if err != nil {
+			return err
+		}
+
+		if reason != "" {
+			log(ctx).Infof("retaining blob %v: %v", bm.BlobID, reason)
+			return nil
+		}
+
+		if !opt.DryRun {
+			if err := rep.BlobStorage().DeleteBlob(ctx, bm.BlobID); err != nil {
+				return errors.Wrapf(err, "error deleting unreferenced blob %v", bm.BlobID)
+			}
+		}
+
+		stats.Count++
+		stats.TotalSize += bm.Length
+
+		pr.report("deleting orphaned blobs", int64(stats.Count), 0, stats.TotalSize, 0, rep.Time())
+
+		return nil
+	})
+
+	return stats, errors.Wrap(err, "error listing unreferenced blobs")
+}
@@ -0,0 +1,34 @@
+package maintenance
+
+import (
+	"context"
+
+	"github.com/kopia/kopia/repo"
+)
+
+// cacheInvalidatingTasks are the tasks whose successful completion can leave
+// in-memory index/content state referring to pack blobs that no longer exist in the
+// backend (a rewrite orphans the pack it rewrote out of; a delete removes it for
+// real). Without re-reading indexes, a long-running process - including the
+// maintenance daemon itself - would keep serving reads against those blobs until its
+// next Refresh, per https://github.com/restic/restic's observation that in-memory
+// indexes go stale after prune/repair.
+var cacheInvalidatingTasks = map[TaskType]bool{
+	TaskRewriteContentsFull:     true,
+	TaskRewriteContentsQuick:    true,
+	TaskDropDeletedContentsFull: true,
+	TaskDeleteOrphanedBlobsFull: true,
+}
+
+// invalidateCachesAfterDestructiveTask re-reads indexes from the backend, via the same
+// rep.Refresh that RunExclusive already calls before maintenance starts, for tasks that
+// just rewrote or deleted pack blobs. It is a no-op for any other task.
+func invalidateCachesAfterDestructiveTask(ctx context.Context, rep repo.DirectRepositoryWriter, taskID TaskType) {
+	if !cacheInvalidatingTasks[taskID] {
+		return
+	}
+
+	if err := rep.Refresh(ctx); err != nil {
+		log(ctx).Errorf("error refreshing indexes after %v: %v", taskID, err)
+	}
+}
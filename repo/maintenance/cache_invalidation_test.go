@@ -0,0 +1,78 @@
+package maintenance
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kopia/kopia/internal/repotesting"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo"
+)
+
+var errRewriteFailed = errors.New("rewrite failed")
+
+// refreshCountingRepo counts calls to Refresh on the wrapped repository.
+type refreshCountingRepo struct {
+	repo.DirectRepositoryWriter
+
+	refreshes int
+}
+
+func (r *refreshCountingRepo) Refresh(ctx context.Context) error {
+	r.refreshes++
+
+	return r.DirectRepositoryWriter.Refresh(ctx)
+}
+
+func TestCacheInvalidatingTasks_Membership(t *testing.T) {
+	require.True(t, cacheInvalidatingTasks[TaskRewriteContentsFull])
+	require.True(t, cacheInvalidatingTasks[TaskRewriteContentsQuick])
+	require.True(t, cacheInvalidatingTasks[TaskDropDeletedContentsFull])
+	require.True(t, cacheInvalidatingTasks[TaskDeleteOrphanedBlobsFull])
+
+	// the quick blob-deletion pass and every other task are left alone.
+	require.False(t, cacheInvalidatingTasks[TaskDeleteOrphanedBlobsQuick])
+	require.False(t, cacheInvalidatingTasks[TaskCleanupLogs])
+	require.False(t, cacheInvalidatingTasks[TaskCleanupEpochManager])
+	require.False(t, cacheInvalidatingTasks[TaskIndexCompaction])
+	require.False(t, cacheInvalidatingTasks[TaskSnapshotGarbageCollection])
+}
+
+func TestInvalidateCachesAfterDestructiveTask_NoOpForUnlistedTask(t *testing.T) {
+	// rep is nil: a task outside cacheInvalidatingTasks must return before touching it.
+	invalidateCachesAfterDestructiveTask(testlogging.Context(t), nil, TaskCleanupLogs)
+}
+
+func TestInvalidateCachesAfterDestructiveTask_RefreshesForListedTask(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	var env repotesting.Environment
+	env.Setup(t)
+
+	rep := &refreshCountingRepo{DirectRepositoryWriter: env.RepositoryWriter}
+
+	invalidateCachesAfterDestructiveTask(ctx, rep, TaskRewriteContentsFull)
+	require.Equal(t, 1, rep.refreshes)
+}
+
+func TestReportRun_OnlyInvalidatesCachesOnSuccess(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	var env repotesting.Environment
+	env.Setup(t)
+
+	rep := &refreshCountingRepo{DirectRepositoryWriter: env.RepositoryWriter}
+	runParams := RunParameters{rep: rep, Mode: ModeFull}
+	s := &Schedule{}
+
+	// a failing run must not trigger Refresh - it didn't actually change anything on
+	// disk, and ReportRun gates invalidateCachesAfterDestructiveTask on runErr == nil.
+	err := ReportRun(ctx, runParams, TaskRewriteContentsFull, s, func() error {
+		return errRewriteFailed
+	})
+	require.ErrorIs(t, err, errRewriteFailed)
+	require.Equal(t, 0, rep.refreshes)
+}
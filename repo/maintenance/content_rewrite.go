@@ -0,0 +1,46 @@
+package maintenance
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/content/index"
+)
+
+// RewriteContentsOptions provides options for RewriteContents.
+type RewriteContentsOptions struct {
+	ContentIDRange index.IDRange
+	PackPrefix     blob.ID
+	ShortPacks     bool
+}
+
+// RewriteContents rewrites contents matching the provided criteria into new pack blobs,
+// orphaning the original packs that held them, and returns the number of contents
+// rewritten. Progress is reported on pr before and after the rewrite (pr may be nil);
+// per-pack granularity is left to a future revision of content.Manager.RewriteContents.
+func RewriteContents(ctx context.Context, rep repo.DirectRepositoryWriter, opt *RewriteContentsOptions, safety SafetyParameters, pr *progressReporter) (int, error) {
+	pr.report("rewriting contents", 0, 0, 0, 0, rep.Time())
+
+	n, err := rep.ContentManager().RewriteContents(ctx, opt.ContentIDRange, opt.PackPrefix, opt.ShortPacks)
+
+	pr.report("rewriting contents", int64(n), int64(n), 0, 0, rep.Time())
+
+	return n, errors.Wrap(err, "error rewriting contents")
+}
+
+// DropDeletedContents rewrites indexes dropping content entries that have been marked
+// as deleted before the provided threshold time, reporting progress on pr (which may
+// be nil) before and after the operation.
+func DropDeletedContents(ctx context.Context, rep repo.DirectRepositoryWriter, threshold time.Time, safety SafetyParameters, pr *progressReporter) error {
+	pr.report("dropping deleted contents", 0, 0, 0, 0, rep.Time())
+
+	err := rep.ContentManager().DropDeletedContents(ctx, threshold)
+
+	pr.report("dropping deleted contents", 1, 1, 0, 0, rep.Time())
+
+	return errors.Wrap(err, "error dropping deleted contents")
+}
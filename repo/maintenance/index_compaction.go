@@ -0,0 +1,20 @@
+package maintenance
+
+import "context"
+
+// runTaskIndexCompactionQuick compacts index blobs, reporting progress on
+// runParams.Progress (which may be unset) only as a 0%->100% pair around the whole
+// call; content.Manager.CompactIndexes doesn't expose a per-index-blob callback.
+func runTaskIndexCompactionQuick(ctx context.Context, runParams RunParameters, s *Schedule, safety SafetyParameters) error {
+	return ReportRun(ctx, runParams, TaskIndexCompaction, s, func() error {
+		pr := newProgressReporter(runParams.Progress, TaskIndexCompaction, runParams.rep.Time())
+		pr.report("compacting indexes", 0, 0, 0, 0, runParams.rep.Time())
+
+		n, err := runParams.rep.ContentManager().CompactIndexes(ctx)
+		runParams.Metrics.AddIndexBlobsCompacted(float64(n))
+
+		pr.report("compacting indexes", int64(n), int64(n), 0, 0, runParams.rep.Time())
+
+		return err
+	})
+}
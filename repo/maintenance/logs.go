@@ -0,0 +1,36 @@
+package maintenance
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+const logsBlobPrefix blob.ID = "_log_"
+
+// CleanupLogs deletes old logs blobs that are older than the provided retention period.
+func CleanupLogs(ctx context.Context, rep repo.DirectRepositoryWriter, retention time.Duration) ([]blob.ID, error) {
+	var deleted []blob.ID
+
+	cutoff := rep.Time().Add(-retention)
+
+	err := rep.BlobReader().ListBlobs(ctx, logsBlobPrefix, func(bm blob.Metadata) error {
+		if bm.Timestamp.After(cutoff) {
+			return nil
+		}
+
+		if err := rep.BlobStorage().DeleteBlob(ctx, bm.BlobID); err != nil {
+			return errors.Wrapf(err, "error deleting log blob %v", bm.BlobID)
+		}
+
+		deleted = append(deleted, bm.BlobID)
+
+		return nil
+	})
+
+	return deleted, errors.Wrap(err, "error cleaning up logs")
+}
@@ -0,0 +1,113 @@
+package maintenance
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+// maintenanceParamsBlobID is the identifier of a blob that holds serialized Params.
+const maintenanceParamsBlobID blob.ID = "kopia.maintenance.params"
+
+// CycleParams defines parameters for a maintenance cycle (quick or full).
+type CycleParams struct {
+	Enabled  bool          `json:"enabled"`
+	Interval time.Duration `json:"interval,omitempty"`
+}
+
+// LockMode determines how the maintenance lock preventing concurrent Run()
+// invocations is acquired.
+type LockMode string
+
+// Supported lock modes.
+const (
+	// LockModeLocal acquires an flock(2)-style lock on a file next to the
+	// repository config, which only protects against concurrent maintenance
+	// on the same host.
+	LockModeLocal LockMode = "local"
+
+	// LockModeRepository acquires a lease blob in the repository backend,
+	// which protects against concurrent maintenance across hosts.
+	LockModeRepository LockMode = "repository"
+)
+
+// OrDefault returns m if non-empty, otherwise LockModeLocal.
+func (m LockMode) OrDefault() LockMode {
+	if m == "" {
+		return LockModeLocal
+	}
+
+	return m
+}
+
+// Params describes parameters for repository maintenance.
+type Params struct {
+	Owner string `json:"owner"`
+
+	QuickCycle CycleParams `json:"quick"`
+	FullCycle  CycleParams `json:"full"`
+
+	// LogRetention, when non-nil, overrides the package default log retention period
+	// used by CleanupLogs. A nil pointer (the JSON zero value, absent from the
+	// marshaled blob because of omitempty) means "unset", distinct from an explicitly
+	// configured retention of 0; use LogRetentionOrDefault to resolve it.
+	LogRetention *time.Duration `json:"logRetention,omitempty"`
+
+	// LockMode determines whether the maintenance lock is acquired locally
+	// (the default, requiring maintenance to be owned by and run as a single
+	// user on a single host) or through a lease blob stored in the repository,
+	// which allows quick or full maintenance to run from any host. Automatic
+	// (ModeAuto) maintenance still runs only when invoked as Owner.
+	LockMode LockMode `json:"lockMode,omitempty"`
+}
+
+// defaultLogRetention is the log retention period used when Params.LogRetention is unset.
+const defaultLogRetention = 30 * 24 * time.Hour
+
+// LogRetentionOrDefault returns p.LogRetention if set, otherwise defaultLogRetention.
+func (p *Params) LogRetentionOrDefault() time.Duration {
+	if p.LogRetention == nil {
+		return defaultLogRetention
+	}
+
+	return *p.LogRetention
+}
+
+func (p *Params) isOwnedByByThisUser(rep repo.DirectRepository) bool {
+	return p.Owner == rep.ClientOptions().UsernameAtHost()
+}
+
+// GetParams gets the current maintenance parameters for a repository.
+func GetParams(ctx context.Context, rep repo.DirectRepository) (*Params, error) {
+	p := &Params{}
+
+	b, err := rep.BlobReader().GetBlob(ctx, maintenanceParamsBlobID, 0, -1)
+	if err != nil {
+		if errors.Is(err, blob.ErrBlobNotFound) {
+			return p, nil
+		}
+
+		return nil, errors.Wrap(err, "error reading maintenance params blob")
+	}
+
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, errors.Wrap(err, "error parsing maintenance params")
+	}
+
+	return p, nil
+}
+
+// SetParams sets the maintenance parameters for a repository.
+func SetParams(ctx context.Context, rep repo.DirectRepositoryWriter, p *Params) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling maintenance params")
+	}
+
+	return errors.Wrap(rep.BlobWriter().PutBlob(ctx, maintenanceParamsBlobID, b), "error writing maintenance params blob")
+}
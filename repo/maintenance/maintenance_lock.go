@@ -0,0 +1,261 @@
+package maintenance
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gofrs/flock"
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+// lockContentionError indicates that the maintenance lock is currently held by
+// another process or host and RunExclusive should quietly skip this invocation.
+type lockContentionError struct {
+	cause error
+}
+
+func (e lockContentionError) Error() string { return e.cause.Error() }
+func (e lockContentionError) Unwrap() error { return e.cause }
+
+func isLockContentionError(err error) bool {
+	var lce lockContentionError
+	return errors.As(err, &lce)
+}
+
+// acquireMaintenanceLock acquires either a local flock-based lock or a distributed
+// lease in the repository backend depending on p.LockMode, returning a function that
+// releases whichever lock was taken. The returned error is a lockContentionError only
+// when the lock is genuinely held by someone else; backend I/O failures are returned
+// as plain errors so callers don't mistake an outage for "maintenance already running".
+func acquireMaintenanceLock(ctx context.Context, rep repo.DirectRepositoryWriter, p *Params) (func(), error) {
+	switch p.LockMode.OrDefault() {
+	case LockModeRepository:
+		return acquireDistributedLock(ctx, rep)
+
+	default:
+		return acquireLocalLock(ctx, rep)
+	}
+}
+
+func acquireLocalLock(ctx context.Context, rep repo.DirectRepositoryWriter) (func(), error) {
+	lockFile := rep.ConfigFilename() + ".mlock"
+	log(ctx).Debugf("Acquiring maintenance lock in file %v", lockFile)
+
+	l := flock.New(lockFile)
+
+	ok, err := l.TryLock()
+	if err != nil {
+		return nil, errors.Wrap(err, "error acquiring local maintenance lock")
+	}
+
+	if !ok {
+		return nil, lockContentionError{errors.Errorf("local maintenance lock %v is held by another process", lockFile)}
+	}
+
+	return func() {
+		l.Unlock() //nolint:errcheck
+	}, nil
+}
+
+// maintenanceLockBlobID is the identifier of the lease blob used to coordinate
+// exclusive maintenance access across hosts when Params.LockMode is LockModeRepository.
+const maintenanceLockBlobID blob.ID = "kopia.maintenance.lock"
+
+const (
+	// distributedLockLeaseTTL is how long a lease remains valid without renewal.
+	distributedLockLeaseTTL = 5 * time.Minute
+
+	// distributedLockRenewInterval is how often the owning process refreshes its lease
+	// while the protected callback is running.
+	distributedLockRenewInterval = 1 * time.Minute
+)
+
+// distributedLease is the payload of the maintenance lock blob.
+type distributedLease struct {
+	Owner        string        `json:"owner"`
+	AcquiredTime time.Time     `json:"acquiredTime"`
+	TTL          time.Duration `json:"ttl"`
+}
+
+// heldLease tracks the lease this process currently believes it owns, so the renewal
+// goroutine and release() can each verify - by re-reading the lease blob and comparing
+// its owner and acquisition time - that the lease hasn't been stolen by another host
+// before acting on it.
+type heldLease struct {
+	mu    sync.Mutex
+	lease distributedLease
+}
+
+func (h *heldLease) get() distributedLease {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.lease
+}
+
+func (h *heldLease) set(l distributedLease) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lease = l
+}
+
+// expired returns true if the lease is no longer valid as of 'now', allowing for
+// clock skew between the host that wrote it and the host checking it, similar to
+// checkClockSkewBounds.
+func (l distributedLease) expired(now time.Time) bool {
+	return now.After(l.AcquiredTime.Add(l.TTL).Add(maxClockSkew))
+}
+
+// acquireDistributedLock acquires the repository-wide maintenance lease, stealing it
+// if the previous holder's lease has expired, and returns a function that releases the
+// lease and stops the background renewal goroutine. It must be called only while
+// rep.BlobReader()/BlobWriter() are usable, i.e. before any destructive task has run.
+func acquireDistributedLock(ctx context.Context, rep repo.DirectRepositoryWriter) (func(), error) {
+	owner := rep.ClientOptions().UsernameAtHost()
+
+	lease, err := tryAcquireLease(ctx, rep, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	held := &heldLease{lease: lease}
+
+	done := make(chan struct{})
+
+	go renewDistributedLease(ctx, rep, held, done)
+
+	release := func() {
+		close(done)
+		releaseDistributedLease(ctx, rep, held)
+	}
+
+	return release, nil
+}
+
+func tryAcquireLease(ctx context.Context, rep repo.DirectRepositoryWriter, owner string) (distributedLease, error) {
+	existing, err := readDistributedLease(ctx, rep)
+	if err != nil {
+		return distributedLease{}, errors.Wrap(err, "error reading distributed maintenance lock")
+	}
+
+	now := rep.Time()
+
+	if existing != nil && !existing.expired(now) {
+		return distributedLease{}, lockContentionError{errors.Errorf("maintenance is already in progress on %v (acquired %v)", existing.Owner, existing.AcquiredTime)}
+	}
+
+	lease := distributedLease{Owner: owner, AcquiredTime: now, TTL: distributedLockLeaseTTL}
+	if err := writeDistributedLease(ctx, rep, lease); err != nil {
+		return distributedLease{}, errors.Wrap(err, "error writing distributed maintenance lock")
+	}
+
+	// Re-read the blob we just wrote to detect a concurrent writer that raced us
+	// between the read above and the write - whichever write landed last wins the
+	// backend's last-writer-wins semantics, so only proceed if it was ours.
+	verify, err := readDistributedLease(ctx, rep)
+	if err != nil {
+		return distributedLease{}, errors.Wrap(err, "error verifying distributed maintenance lock")
+	}
+
+	if verify == nil || verify.Owner != owner || !verify.AcquiredTime.Equal(lease.AcquiredTime) {
+		return distributedLease{}, lockContentionError{errors.Errorf("lost race acquiring distributed maintenance lock")}
+	}
+
+	return lease, nil
+}
+
+// renewDistributedLease periodically refreshes held's lease so it doesn't expire while
+// the protected callback is still running. Before each renewal it re-reads the lease
+// blob and verifies it still matches what held believes it owns; if another host has
+// already stolen the lease (because this host stalled past the TTL), renewal stops
+// instead of overwriting the new owner's lease with a stale copy.
+func renewDistributedLease(ctx context.Context, rep repo.DirectRepositoryWriter, held *heldLease, done <-chan struct{}) {
+	t := time.NewTicker(distributedLockRenewInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+			current := held.get()
+
+			existing, err := readDistributedLease(ctx, rep)
+			if err != nil {
+				log(ctx).Errorf("error reading distributed maintenance lock before renewal: %v", err)
+				continue
+			}
+
+			if existing == nil || existing.Owner != current.Owner || !existing.AcquiredTime.Equal(current.AcquiredTime) {
+				log(ctx).Errorf("distributed maintenance lock was stolen by another host; stopping renewal")
+				return
+			}
+
+			renewed := current
+			renewed.AcquiredTime = rep.Time()
+
+			if err := writeDistributedLease(ctx, rep, renewed); err != nil {
+				log(ctx).Errorf("error renewing distributed maintenance lock: %v", err)
+				continue
+			}
+
+			held.set(renewed)
+		}
+	}
+}
+
+// releaseDistributedLease deletes the lease blob, but only if it still matches the
+// lease held believes it owns - the same compare-and-swap renewDistributedLease performs -
+// so that releasing after this host's lease has already been stolen doesn't delete the
+// new owner's valid lease out from under it.
+func releaseDistributedLease(ctx context.Context, rep repo.DirectRepositoryWriter, held *heldLease) {
+	current := held.get()
+
+	existing, err := readDistributedLease(ctx, rep)
+	if err != nil {
+		log(ctx).Errorf("error reading distributed maintenance lock before release: %v", err)
+		return
+	}
+
+	if existing == nil || existing.Owner != current.Owner || !existing.AcquiredTime.Equal(current.AcquiredTime) {
+		log(ctx).Debugf("distributed maintenance lock was already stolen by another host; not releasing")
+		return
+	}
+
+	if err := rep.BlobStorage().DeleteBlob(ctx, maintenanceLockBlobID); err != nil {
+		log(ctx).Errorf("error releasing distributed maintenance lock: %v", err)
+	}
+}
+
+func readDistributedLease(ctx context.Context, rep repo.DirectRepository) (*distributedLease, error) {
+	b, err := rep.BlobReader().GetBlob(ctx, maintenanceLockBlobID, 0, -1)
+	if err != nil {
+		if errors.Is(err, blob.ErrBlobNotFound) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	l := &distributedLease{}
+	if err := json.Unmarshal(b, l); err != nil {
+		return nil, errors.Wrap(err, "error parsing distributed maintenance lock")
+	}
+
+	return l, nil
+}
+
+func writeDistributedLease(ctx context.Context, rep repo.DirectRepositoryWriter, lease distributedLease) error {
+	b, err := json.Marshal(lease)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling distributed maintenance lock")
+	}
+
+	return rep.BlobWriter().PutBlob(ctx, maintenanceLockBlobID, b)
+}
@@ -0,0 +1,76 @@
+package maintenance
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kopia/kopia/internal/repotesting"
+	"github.com/kopia/kopia/internal/testlogging"
+)
+
+func TestDistributedLeaseExpired(t *testing.T) {
+	acquired := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := distributedLease{AcquiredTime: acquired, TTL: distributedLockLeaseTTL}
+
+	require.False(t, l.expired(acquired))
+	require.False(t, l.expired(acquired.Add(distributedLockLeaseTTL)))
+	require.False(t, l.expired(acquired.Add(distributedLockLeaseTTL).Add(maxClockSkew)))
+	require.True(t, l.expired(acquired.Add(distributedLockLeaseTTL).Add(maxClockSkew).Add(time.Second)))
+}
+
+func TestTryAcquireLease_ContentionWhileHeld(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	var env repotesting.Environment
+	env.Setup(t)
+
+	_, err := tryAcquireLease(ctx, env.RepositoryWriter, "alice@host1")
+	require.NoError(t, err)
+
+	_, err = tryAcquireLease(ctx, env.RepositoryWriter, "bob@host2")
+	require.Error(t, err)
+	require.True(t, isLockContentionError(err))
+}
+
+func TestTryAcquireLease_StealsExpiredLease(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	var env repotesting.Environment
+	env.Setup(t)
+
+	expired := distributedLease{
+		Owner:        "alice@host1",
+		AcquiredTime: env.RepositoryWriter.Time().Add(-2*distributedLockLeaseTTL - time.Hour),
+		TTL:          distributedLockLeaseTTL,
+	}
+	require.NoError(t, writeDistributedLease(ctx, env.RepositoryWriter, expired))
+
+	lease, err := tryAcquireLease(ctx, env.RepositoryWriter, "bob@host2")
+	require.NoError(t, err)
+	require.Equal(t, "bob@host2", lease.Owner)
+}
+
+func TestReleaseDistributedLease_DoesNotDeleteStolenLease(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	var env repotesting.Environment
+	env.Setup(t)
+
+	lease, err := tryAcquireLease(ctx, env.RepositoryWriter, "alice@host1")
+	require.NoError(t, err)
+
+	held := &heldLease{lease: lease}
+
+	// Simulate another host stealing the lease after it expired on this host.
+	stolen := distributedLease{Owner: "bob@host2", AcquiredTime: env.RepositoryWriter.Time(), TTL: distributedLockLeaseTTL}
+	require.NoError(t, writeDistributedLease(ctx, env.RepositoryWriter, stolen))
+
+	releaseDistributedLease(ctx, env.RepositoryWriter, held)
+
+	existing, err := readDistributedLease(ctx, env.RepositoryWriter)
+	require.NoError(t, err)
+	require.NotNil(t, existing)
+	require.Equal(t, "bob@host2", existing.Owner)
+}
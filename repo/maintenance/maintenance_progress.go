@@ -0,0 +1,61 @@
+package maintenance
+
+import "time"
+
+// TaskProgress describes progress of a long-running maintenance task, reported on the
+// channel supplied through RunOptions.Progress. Granularity varies by task:
+// DeleteUnreferencedBlobs reports once per blob it deletes; RewriteContents,
+// DropDeletedContents, and index compaction only bracket the whole call with a
+// 0%->100% pair, since the underlying content.Manager operations don't yet expose a
+// per-item callback.
+type TaskProgress struct {
+	Task  TaskType
+	Phase string
+
+	ItemsProcessed int64
+	ItemsTotal     int64 // 0 if not known in advance
+
+	BytesProcessed int64
+	BytesTotal     int64 // 0 if not known in advance
+
+	StartedAt time.Time
+	UpdatedAt time.Time
+}
+
+// progressReporter emits TaskProgress updates for a single task invocation onto an
+// optional channel. A nil receiver and a nil channel are both valid and make report()
+// a no-op, so callers that were not given a RunOptions.Progress channel pay nothing.
+type progressReporter struct {
+	ch        chan<- TaskProgress
+	task      TaskType
+	startedAt time.Time
+}
+
+func newProgressReporter(ch chan<- TaskProgress, task TaskType, startedAt time.Time) *progressReporter {
+	return &progressReporter{ch: ch, task: task, startedAt: startedAt}
+}
+
+// report sends an update describing the given phase and counters, dropping it instead
+// of blocking if the channel is unbuffered/full so a slow consumer never stalls
+// maintenance.
+func (r *progressReporter) report(phase string, itemsProcessed, itemsTotal, bytesProcessed, bytesTotal int64, now time.Time) {
+	if r == nil || r.ch == nil {
+		return
+	}
+
+	update := TaskProgress{
+		Task:           r.task,
+		Phase:          phase,
+		ItemsProcessed: itemsProcessed,
+		ItemsTotal:     itemsTotal,
+		BytesProcessed: bytesProcessed,
+		BytesTotal:     bytesTotal,
+		StartedAt:      r.startedAt,
+		UpdatedAt:      now,
+	}
+
+	select {
+	case r.ch <- update:
+	default:
+	}
+}
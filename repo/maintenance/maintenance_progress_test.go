@@ -0,0 +1,60 @@
+package maintenance
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgressReporter_SendsUpdate(t *testing.T) {
+	ch := make(chan TaskProgress, 1)
+	started := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := started.Add(time.Minute)
+
+	pr := newProgressReporter(ch, TaskRewriteContentsFull, started)
+	pr.report("rewriting contents", 5, 10, 50, 100, now)
+
+	update := <-ch
+	require.Equal(t, TaskProgress{
+		Task:           TaskRewriteContentsFull,
+		Phase:          "rewriting contents",
+		ItemsProcessed: 5,
+		ItemsTotal:     10,
+		BytesProcessed: 50,
+		BytesTotal:     100,
+		StartedAt:      started,
+		UpdatedAt:      now,
+	}, update)
+}
+
+func TestProgressReporter_DropsUpdateInsteadOfBlocking(t *testing.T) {
+	ch := make(chan TaskProgress) // unbuffered: any send blocks without a reader.
+
+	pr := newProgressReporter(ch, TaskIndexCompaction, time.Now())
+
+	done := make(chan struct{})
+	go func() {
+		pr.report("compacting indexes", 1, 1, 0, 0, time.Now())
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("report() blocked on an unbuffered channel with no reader")
+	}
+}
+
+func TestProgressReporter_NilReceiverAndNilChannelAreNoOps(t *testing.T) {
+	var nilReporter *progressReporter
+
+	require.NotPanics(t, func() {
+		nilReporter.report("phase", 0, 0, 0, 0, time.Now())
+	})
+
+	withNilChannel := newProgressReporter(nil, TaskCleanupLogs, time.Now())
+	require.NotPanics(t, func() {
+		withNilChannel.report("phase", 0, 0, 0, 0, time.Now())
+	})
+}
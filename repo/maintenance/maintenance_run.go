@@ -6,7 +6,6 @@ import (
 	"sort"
 	"time"
 
-	"github.com/gofrs/flock"
 	"github.com/pkg/errors"
 
 	"github.com/kopia/kopia/internal/clock"
@@ -14,6 +13,7 @@ import (
 	"github.com/kopia/kopia/repo/content"
 	"github.com/kopia/kopia/repo/content/index"
 	"github.com/kopia/kopia/repo/logging"
+	"github.com/kopia/kopia/repo/maintenance/metrics"
 )
 
 var log = logging.Module("maintenance")
@@ -128,6 +128,19 @@ type RunParameters struct {
 
 	// timestamp of the last update of maintenance schedule blob
 	MaintenanceStartTime time.Time
+
+	// Opts carries options for this specific invocation, set by Run().
+	Opts RunOptions
+
+	// Metrics, when non-nil, receives task duration/result/result-specific counters
+	// as tasks run. A nil Metrics is safe to use - all of its methods are no-ops.
+	Metrics *metrics.Registry
+
+	// Progress, when non-nil, receives TaskProgress updates as the current task runs.
+	Progress chan<- TaskProgress
+
+	// OrphanProtectors are consulted before deleting any blob that appears orphaned.
+	OrphanProtectors []OrphanProtector
 }
 
 // NotOwnedError is returned when maintenance cannot run because it is owned by another user.
@@ -152,7 +165,12 @@ func RunExclusive(ctx context.Context, rep repo.DirectRepositoryWriter, mode Mod
 		return errors.Wrap(err, "unable to get maintenance params")
 	}
 
-	if !force && !p.isOwnedByByThisUser(rep) {
+	// The owned-by-one-user restriction only makes sense for LockModeLocal, where
+	// a flock(2) lock can't arbitrate across hosts; LockModeRepository lets any host
+	// run maintenance in ModeQuick or ModeFull and relies on the distributed lease for
+	// exclusivity instead. ModeAuto still goes through shouldRun, which returns
+	// ModeNone for anyone other than p.Owner.
+	if !force && p.LockMode.OrDefault() != LockModeRepository && !p.isOwnedByByThisUser(rep) {
 		return NotOwnedError{p.Owner}
 	}
 
@@ -168,25 +186,19 @@ func RunExclusive(ctx context.Context, rep repo.DirectRepositoryWriter, mode Mod
 		return nil
 	}
 
-	lockFile := rep.ConfigFilename() + ".mlock"
-	log(ctx).Debugf("Acquiring maintenance lock in file %v", lockFile)
-
-	// acquire local lock on a config file
-	l := flock.New(lockFile)
-
-	ok, err := l.TryLock()
+	release, err := acquireMaintenanceLock(ctx, rep, p)
 	if err != nil {
-		return errors.Wrap(err, "error acquiring maintenance lock")
-	}
+		if isLockContentionError(err) {
+			log(ctx).Debugf("maintenance is already in progress: %v", err)
+			return nil
+		}
 
-	if !ok {
-		log(ctx).Debugf("maintenance is already in progress locally")
-		return nil
+		return errors.Wrap(err, "error acquiring maintenance lock")
 	}
 
-	defer l.Unlock() //nolint:errcheck
+	defer release()
 
-	runParams := RunParameters{rep, mode, p, time.Time{}}
+	runParams := RunParameters{rep: rep, Mode: mode, Params: p}
 
 	// update schedule so that we don't run the maintenance again immediately if
 	// this process crashes.
@@ -231,8 +243,19 @@ func checkClockSkewBounds(rp RunParameters) error {
 	return nil
 }
 
-// Run performs maintenance activities for a repository.
-func Run(ctx context.Context, runParams RunParameters, safety SafetyParameters) error {
+// Run performs maintenance activities for a repository, running every task whose
+// TaskSpec.RunOn matches runParams.Mode and which is not ManualOnly (or, with
+// opts.TaskFilter set, exactly the listed tasks regardless of mode) in declared
+// priority order. Without a TaskFilter, quick maintenance runs no tasks at all on a
+// repository that uses an epoch manager.
+func Run(ctx context.Context, runParams RunParameters, safety SafetyParameters, opts RunOptions) error {
+	runParams.Opts = opts
+	runParams.Metrics = opts.Metrics
+	runParams.Progress = opts.Progress
+
+	// the manifest-backed default protector always applies, ahead of any caller-supplied ones.
+	runParams.OrphanProtectors = append([]OrphanProtector{NewManifestRetentionProtector(runParams.rep)}, opts.OrphanProtectors...)
+
 	switch runParams.Mode {
 	case ModeQuick:
 		return runQuickMaintenance(ctx, runParams, safety)
@@ -246,63 +269,19 @@ func Run(ctx context.Context, runParams RunParameters, safety SafetyParameters)
 }
 
 func runQuickMaintenance(ctx context.Context, runParams RunParameters, safety SafetyParameters) error {
-	_, ok, emerr := runParams.rep.ContentManager().EpochManager()
-	if ok {
-		log(ctx).Debugf("quick maintenance not required for epoch manager")
-		return nil
-	}
-
-	if emerr != nil {
-		return errors.Wrap(emerr, "epoch manager")
-	}
-
-	s, err := GetSchedule(ctx, runParams.rep)
-	if err != nil {
-		return errors.Wrap(err, "unable to get schedule")
-	}
-
-	if shouldQuickRewriteContents(s, safety) {
-		// find 'q' packs that are less than 80% full and rewrite contents in them into
-		// new consolidated packs, orphaning old packs in the process.
-		if err := runTaskRewriteContentsQuick(ctx, runParams, s, safety); err != nil {
-			return errors.Wrap(err, "error rewriting metadata contents")
+	if len(runParams.Opts.TaskFilter) == 0 {
+		_, ok, emerr := runParams.rep.ContentManager().EpochManager()
+		if ok {
+			log(ctx).Debugf("quick maintenance not required for epoch manager")
+			return nil
 		}
-	} else {
-		notRewritingContents(ctx)
-	}
 
-	if shouldDeleteOrphanedPacks(runParams.rep.Time(), s, safety) {
-		var err error
-
-		// time to delete orphaned blobs after last rewrite,
-		// if the last rewrite was full (started as part of full maintenance) we must complete it by
-		// running full orphaned blob deletion, otherwise next quick maintenance will start a quick rewrite
-		// and we'd never delete blobs orphaned by full rewrite.
-		if hadRecentFullRewrite(s) {
-			log(ctx).Debugf("Had recent full rewrite - performing full blob deletion.")
-			err = runTaskDeleteOrphanedBlobsFull(ctx, runParams, s, safety)
-		} else {
-			log(ctx).Debugf("Performing quick blob deletion.")
-			err = runTaskDeleteOrphanedBlobsQuick(ctx, runParams, s, safety)
+		if emerr != nil {
+			return errors.Wrap(emerr, "epoch manager")
 		}
-
-		if err != nil {
-			return errors.Wrap(err, "error deleting unreferenced metadata blobs")
-		}
-	} else {
-		notDeletingOrphanedBlobs(ctx, s, safety)
 	}
 
-	// consolidate many smaller indexes into fewer larger ones.
-	if err := runTaskIndexCompactionQuick(ctx, runParams, s, safety); err != nil {
-		return errors.Wrap(err, "error performing index compaction")
-	}
-
-	if err := runTaskCleanupLogs(ctx, runParams, s); err != nil {
-		return errors.Wrap(err, "error cleaning up logs")
-	}
-
-	return nil
+	return runRegisteredTasks(ctx, runParams, safety, RunOnQuick, runParams.Opts.TaskFilter)
 }
 
 func notRewritingContents(ctx context.Context) {
@@ -316,8 +295,8 @@ func notDeletingOrphanedBlobs(ctx context.Context, s *Schedule, safety SafetyPar
 }
 
 func runTaskCleanupLogs(ctx context.Context, runParams RunParameters, s *Schedule) error {
-	return ReportRun(ctx, runParams.rep, TaskCleanupLogs, s, func() error {
-		deleted, err := CleanupLogs(ctx, runParams.rep, runParams.Params.LogRetention.OrDefault())
+	return ReportRun(ctx, runParams, TaskCleanupLogs, s, func() error {
+		deleted, err := CleanupLogs(ctx, runParams.rep, runParams.Params.LogRetentionOrDefault())
 
 		log(ctx).Infof("Cleaned up %v logs.", len(deleted))
 
@@ -335,7 +314,7 @@ func runTaskCleanupEpochManager(ctx context.Context, runParams RunParameters, s
 		return nil
 	}
 
-	return ReportRun(ctx, runParams.rep, TaskCleanupEpochManager, s, func() error {
+	return ReportRun(ctx, runParams, TaskCleanupEpochManager, s, func() error {
 		log(ctx).Infof("Cleaning up old index blobs which have already been compacted...")
 		return errors.Wrap(em.CleanupSupersededIndexes(ctx), "error cleaning up superseded index blobs")
 	})
@@ -357,89 +336,70 @@ func runTaskDropDeletedContentsFull(ctx context.Context, runParams RunParameters
 
 	log(ctx).Infof("Found safe time to drop indexes: %v", safeDropTime)
 
-	return ReportRun(ctx, runParams.rep, TaskDropDeletedContentsFull, s, func() error {
-		return DropDeletedContents(ctx, runParams.rep, safeDropTime, safety)
+	return ReportRun(ctx, runParams, TaskDropDeletedContentsFull, s, func() error {
+		pr := newProgressReporter(runParams.Progress, TaskDropDeletedContentsFull, runParams.rep.Time())
+		return DropDeletedContents(ctx, runParams.rep, safeDropTime, safety, pr)
 	})
 }
 
 func runTaskRewriteContentsQuick(ctx context.Context, runParams RunParameters, s *Schedule, safety SafetyParameters) error {
-	return ReportRun(ctx, runParams.rep, TaskRewriteContentsQuick, s, func() error {
-		return RewriteContents(ctx, runParams.rep, &RewriteContentsOptions{
+	return ReportRun(ctx, runParams, TaskRewriteContentsQuick, s, func() error {
+		pr := newProgressReporter(runParams.Progress, TaskRewriteContentsQuick, runParams.rep.Time())
+		n, err := RewriteContents(ctx, runParams.rep, &RewriteContentsOptions{
 			ContentIDRange: index.AllPrefixedIDs,
 			PackPrefix:     content.PackBlobIDPrefixSpecial,
 			ShortPacks:     true,
-		}, safety)
+		}, safety, pr)
+		runParams.Metrics.AddContentsRewritten(float64(n))
+
+		return err
 	})
 }
 
 func runTaskRewriteContentsFull(ctx context.Context, runParams RunParameters, s *Schedule, safety SafetyParameters) error {
-	return ReportRun(ctx, runParams.rep, TaskRewriteContentsFull, s, func() error {
-		return RewriteContents(ctx, runParams.rep, &RewriteContentsOptions{
+	return ReportRun(ctx, runParams, TaskRewriteContentsFull, s, func() error {
+		pr := newProgressReporter(runParams.Progress, TaskRewriteContentsFull, runParams.rep.Time())
+		n, err := RewriteContents(ctx, runParams.rep, &RewriteContentsOptions{
 			ContentIDRange: index.AllIDs,
 			ShortPacks:     true,
-		}, safety)
+		}, safety, pr)
+		runParams.Metrics.AddContentsRewritten(float64(n))
+
+		return err
 	})
 }
 
 func runTaskDeleteOrphanedBlobsFull(ctx context.Context, runParams RunParameters, s *Schedule, safety SafetyParameters) error {
-	return ReportRun(ctx, runParams.rep, TaskDeleteOrphanedBlobsFull, s, func() error {
-		_, err := DeleteUnreferencedBlobs(ctx, runParams.rep, DeleteUnreferencedBlobsOptions{
+	return ReportRun(ctx, runParams, TaskDeleteOrphanedBlobsFull, s, func() error {
+		pr := newProgressReporter(runParams.Progress, TaskDeleteOrphanedBlobsFull, runParams.rep.Time())
+		stats, err := DeleteUnreferencedBlobs(ctx, runParams.rep, DeleteUnreferencedBlobsOptions{
 			NotAfterTime: runParams.MaintenanceStartTime,
-		}, safety)
+			Protectors:   runParams.OrphanProtectors,
+		}, safety, pr)
+		runParams.Metrics.AddBlobsDeleted(float64(stats.Count))
+		runParams.Metrics.AddBytesReclaimed(float64(stats.TotalSize))
+
 		return err
 	})
 }
 
 func runTaskDeleteOrphanedBlobsQuick(ctx context.Context, runParams RunParameters, s *Schedule, safety SafetyParameters) error {
-	return ReportRun(ctx, runParams.rep, TaskDeleteOrphanedBlobsQuick, s, func() error {
-		_, err := DeleteUnreferencedBlobs(ctx, runParams.rep, DeleteUnreferencedBlobsOptions{
+	return ReportRun(ctx, runParams, TaskDeleteOrphanedBlobsQuick, s, func() error {
+		pr := newProgressReporter(runParams.Progress, TaskDeleteOrphanedBlobsQuick, runParams.rep.Time())
+		stats, err := DeleteUnreferencedBlobs(ctx, runParams.rep, DeleteUnreferencedBlobsOptions{
 			NotAfterTime: runParams.MaintenanceStartTime,
 			Prefix:       content.PackBlobIDPrefixSpecial,
-		}, safety)
+			Protectors:   runParams.OrphanProtectors,
+		}, safety, pr)
+		runParams.Metrics.AddBlobsDeleted(float64(stats.Count))
+		runParams.Metrics.AddBytesReclaimed(float64(stats.TotalSize))
+
 		return err
 	})
 }
 
 func runFullMaintenance(ctx context.Context, runParams RunParameters, safety SafetyParameters) error {
-	s, err := GetSchedule(ctx, runParams.rep)
-	if err != nil {
-		return errors.Wrap(err, "unable to get schedule")
-	}
-
-	if shouldFullRewriteContents(s, safety) {
-		// find packs that are less than 80% full and rewrite contents in them into
-		// new consolidated packs, orphaning old packs in the process.
-		if err := runTaskRewriteContentsFull(ctx, runParams, s, safety); err != nil {
-			return errors.Wrap(err, "error rewriting contents in short packs")
-		}
-	} else {
-		notRewritingContents(ctx)
-	}
-
-	// rewrite indexes by dropping content entries that have been marked
-	// as deleted for a long time
-	if err := runTaskDropDeletedContentsFull(ctx, runParams, s, safety); err != nil {
-		return errors.Wrap(err, "error dropping deleted contents")
-	}
-
-	if shouldDeleteOrphanedPacks(runParams.rep.Time(), s, safety) {
-		// delete orphaned packs after some time.
-		if err := runTaskDeleteOrphanedBlobsFull(ctx, runParams, s, safety); err != nil {
-			return errors.Wrap(err, "error deleting unreferenced blobs")
-		}
-	} else {
-		notDeletingOrphanedBlobs(ctx, s, safety)
-	}
-
-	if err := runTaskCleanupLogs(ctx, runParams, s); err != nil {
-		return errors.Wrap(err, "error cleaning up logs")
-	}
-
-	if err := runTaskCleanupEpochManager(ctx, runParams, s); err != nil {
-		return errors.Wrap(err, "error cleaning up epoch manager")
-	}
-
-	return nil
+	return runRegisteredTasks(ctx, runParams, safety, RunOnFull, runParams.Opts.TaskFilter)
 }
 
 // shouldRewriteContents returns true if it's currently ok to rewrite contents.
@@ -0,0 +1,24 @@
+package maintenance
+
+import "time"
+
+// SafetyParameters specifies the parameters to ensure safety of maintenance operations.
+type SafetyParameters struct {
+	RequireTwoGCCycles               bool
+	DropContentFromIndexExtraMargin  time.Duration
+	MarginBetweenSnapshotGC          time.Duration
+	MinRewriteToOrphanDeletionDelay  time.Duration
+	DisableEventualConsistencySafety bool
+}
+
+// SafetyFull is a maintenance safety setting that minimizes chances of losing data.
+var SafetyFull = SafetyParameters{
+	RequireTwoGCCycles:              true,
+	DropContentFromIndexExtraMargin: 24 * time.Hour,
+	MarginBetweenSnapshotGC:         4 * time.Hour,
+	MinRewriteToOrphanDeletionDelay: 1 * time.Hour,
+}
+
+// SafetyNone is a maintenance safety setting that does not provide any safety checks,
+// to be used if the repository is not being written to by any other system.
+var SafetyNone = SafetyParameters{}
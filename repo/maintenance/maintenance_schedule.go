@@ -0,0 +1,117 @@
+package maintenance
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+// maintenanceScheduleBlobID is the identifier of a blob that holds serialized Schedule.
+const maintenanceScheduleBlobID blob.ID = "kopia.maintenance.schedule"
+
+// RunInfo represents a single run of a maintenance task.
+type RunInfo struct {
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Success bool      `json:"success"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// Schedule keeps track of scheduled maintenance times and previous runs of each task.
+type Schedule struct {
+	NextFullMaintenanceTime  time.Time              `json:"nextFullMaintenanceTime,omitempty"`
+	NextQuickMaintenanceTime time.Time              `json:"nextQuickMaintenanceTime,omitempty"`
+	Runs                     map[TaskType][]RunInfo `json:"runs"`
+}
+
+// reportRunStart appends a new RunInfo with the given start time.
+func (s *Schedule) reportRunStart(taskID TaskType, start time.Time) {
+	if s.Runs == nil {
+		s.Runs = map[TaskType][]RunInfo{}
+	}
+
+	s.Runs[taskID] = append(s.Runs[taskID], RunInfo{Start: start})
+}
+
+// GetSchedule gets the scheduling information for a repository.
+func GetSchedule(ctx context.Context, rep repo.DirectRepository) (*Schedule, error) {
+	s := &Schedule{}
+
+	b, err := rep.BlobReader().GetBlob(ctx, maintenanceScheduleBlobID, 0, -1)
+	if err != nil {
+		if errors.Is(err, blob.ErrBlobNotFound) {
+			return s, nil
+		}
+
+		return nil, errors.Wrap(err, "error reading maintenance schedule blob")
+	}
+
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, errors.Wrap(err, "error parsing maintenance schedule")
+	}
+
+	return s, nil
+}
+
+// SetSchedule sets the scheduling information for a repository.
+func SetSchedule(ctx context.Context, rep repo.DirectRepositoryWriter, s *Schedule) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling maintenance schedule")
+	}
+
+	return errors.Wrap(rep.BlobWriter().PutBlob(ctx, maintenanceScheduleBlobID, b), "error writing maintenance schedule blob")
+}
+
+// ReportRun invokes the given callback and records its outcome as a RunInfo for the
+// provided task, persisting the resulting schedule regardless of success or failure
+// and, when runParams.Metrics is set, exporting its duration and result as metrics.
+func ReportRun(ctx context.Context, runParams RunParameters, taskID TaskType, s *Schedule, run func() error) error {
+	rep := runParams.rep
+
+	runErr := runTaskAndReport(ctx, rep, taskID, s, run)
+	if err := SetSchedule(ctx, rep, s); err != nil {
+		log(ctx).Errorf("unable to save schedule after running %v: %v", taskID, err)
+	}
+
+	ri := s.Runs[taskID][len(s.Runs[taskID])-1]
+	result := "success"
+
+	if !ri.Success {
+		result = "error"
+	}
+
+	runParams.Metrics.ObserveTaskRun(string(taskID), string(runParams.Mode), result, ri.End.Sub(ri.Start), ri.End)
+
+	if runErr == nil {
+		invalidateCachesAfterDestructiveTask(ctx, rep, taskID)
+	}
+
+	return runErr
+}
+
+func runTaskAndReport(ctx context.Context, rep repo.DirectRepositoryWriter, taskID TaskType, s *Schedule, run func() error) error {
+	ri := RunInfo{Start: rep.Time()}
+
+	err := run()
+
+	ri.End = rep.Time()
+	ri.Success = err == nil
+
+	if err != nil {
+		ri.Error = err.Error()
+	}
+
+	if s.Runs == nil {
+		s.Runs = map[TaskType][]RunInfo{}
+	}
+
+	s.Runs[taskID] = append(s.Runs[taskID], ri)
+
+	return err
+}
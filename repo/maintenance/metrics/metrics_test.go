@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry_ObserveTaskRunAndCounters(t *testing.T) {
+	r := NewRegistry(prometheus.NewRegistry())
+
+	r.ObserveTaskRun("full-rewrite-contents", "full", "success", 2*time.Second, time.Unix(1000, 0))
+	require.InDelta(t, 1, testutil.ToFloat64(r.taskRuns.WithLabelValues("full-rewrite-contents", "success")), 0)
+	require.InDelta(t, 1000, testutil.ToFloat64(r.lastSuccess.WithLabelValues("full-rewrite-contents")), 0)
+
+	r.ObserveTaskRun("full-rewrite-contents", "full", "error", time.Second, time.Unix(2000, 0))
+	require.InDelta(t, 1, testutil.ToFloat64(r.taskRuns.WithLabelValues("full-rewrite-contents", "error")), 0)
+	// a failed run must not move last-success forward.
+	require.InDelta(t, 1000, testutil.ToFloat64(r.lastSuccess.WithLabelValues("full-rewrite-contents")), 0)
+
+	r.AddBlobsDeleted(3)
+	r.AddBlobsDeleted(4)
+	require.InDelta(t, 7, testutil.ToFloat64(r.blobsDeleted), 0)
+
+	r.AddBytesReclaimed(100)
+	require.InDelta(t, 100, testutil.ToFloat64(r.bytesReclaimed), 0)
+
+	r.AddContentsRewritten(5)
+	require.InDelta(t, 5, testutil.ToFloat64(r.contentsRewritten), 0)
+
+	r.AddIndexBlobsCompacted(2)
+	require.InDelta(t, 2, testutil.ToFloat64(r.indexBlobsCompact), 0)
+}
+
+func TestRegistry_NilReceiverIsNoOp(t *testing.T) {
+	var r *Registry
+
+	require.NotPanics(t, func() {
+		r.ObserveTaskRun("task", "quick", "success", time.Second, time.Now())
+		r.AddBlobsDeleted(1)
+		r.AddBytesReclaimed(1)
+		r.AddContentsRewritten(1)
+		r.AddIndexBlobsCompacted(1)
+	})
+}
@@ -0,0 +1,120 @@
+package maintenance
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/manifest"
+)
+
+// OrphanProtector allows external code to veto deletion of a blob that maintenance
+// has otherwise determined to be orphaned (unreferenced by any index and old enough
+// to delete). This unlocks integrations such as "don't delete blobs referenced by an
+// external replica catalog", "hold blobs flagged by legal hold for N days", or
+// "preserve blobs recently written by another host whose indexes we haven't yet
+// observed" - cases the NotAfterTime guard in DeleteUnreferencedBlobsOptions alone
+// cannot express.
+type OrphanProtector interface {
+	// ShouldRetain returns true, together with a human-readable reason, if blobID must
+	// not be deleted even though it appears orphaned.
+	ShouldRetain(ctx context.Context, blobID blob.ID) (bool, string, error)
+}
+
+// protectBlob consults protectors in order and returns the reason given by the first
+// one that votes to retain blobID, or "" if none object.
+func protectBlob(ctx context.Context, protectors []OrphanProtector, blobID blob.ID) (string, error) {
+	for _, p := range protectors {
+		retain, reason, err := p.ShouldRetain(ctx, blobID)
+		if err != nil {
+			return "", errors.Wrapf(err, "error consulting orphan protector for blob %v", blobID)
+		}
+
+		if retain {
+			return reason, nil
+		}
+	}
+
+	return "", nil
+}
+
+// retentionManifestType is the manifest type used by ManifestRetentionProtector.
+const retentionManifestType = "kopia.retention.blob"
+
+// retentionManifestPayload is the JSON payload of a retention manifest.
+type retentionManifestPayload struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+// ManifestRetentionProtector is a default OrphanProtector backed by a manifest list,
+// letting users pin individual blob IDs (via "kopia.retention.*" manifests) without
+// writing Go code.
+type ManifestRetentionProtector struct {
+	rep repo.DirectRepository
+}
+
+// NewManifestRetentionProtector returns an OrphanProtector that retains any blob whose
+// ID has a corresponding "kopia.retention.blob" manifest entry.
+func NewManifestRetentionProtector(rep repo.DirectRepository) *ManifestRetentionProtector {
+	return &ManifestRetentionProtector{rep: rep}
+}
+
+// ShouldRetain implements OrphanProtector.
+func (p *ManifestRetentionProtector) ShouldRetain(ctx context.Context, blobID blob.ID) (bool, string, error) {
+	entries, err := p.rep.FindManifests(ctx, map[string]string{
+		manifest.TypeLabelKey: retentionManifestType,
+		"blobID":              string(blobID),
+	})
+	if err != nil {
+		return false, "", errors.Wrap(err, "error finding retention manifests")
+	}
+
+	if len(entries) == 0 {
+		return false, "", nil
+	}
+
+	var payload retentionManifestPayload
+
+	if _, err := p.rep.GetManifest(ctx, entries[0].ID, &payload); err != nil {
+		return false, "", errors.Wrap(err, "error reading retention manifest")
+	}
+
+	if payload.Reason == "" {
+		return true, "pinned by kopia.retention manifest", nil
+	}
+
+	return true, payload.Reason, nil
+}
+
+// PinBlobForRetention creates a manifest that causes blobID to be retained by
+// ManifestRetentionProtector until UnpinBlobFromRetention is called.
+func PinBlobForRetention(ctx context.Context, rep repo.DirectRepositoryWriter, blobID blob.ID, reason string) error {
+	_, err := rep.PutManifest(ctx, map[string]string{
+		manifest.TypeLabelKey: retentionManifestType,
+		"blobID":              string(blobID),
+	}, retentionManifestPayload{Reason: reason})
+
+	return errors.Wrap(err, "error writing retention manifest")
+}
+
+// UnpinBlobFromRetention removes any retention manifests pinning blobID, allowing it
+// to be deleted as orphaned again.
+func UnpinBlobFromRetention(ctx context.Context, rep repo.DirectRepositoryWriter, blobID blob.ID) error {
+	entries, err := rep.FindManifests(ctx, map[string]string{
+		manifest.TypeLabelKey: retentionManifestType,
+		"blobID":              string(blobID),
+	})
+	if err != nil {
+		return errors.Wrap(err, "error finding retention manifests")
+	}
+
+	for _, e := range entries {
+		if err := rep.DeleteManifest(ctx, e.ID); err != nil {
+			return errors.Wrapf(err, "error deleting retention manifest %v", e.ID)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package maintenance
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kopia/kopia/internal/repotesting"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+func TestManifestRetentionProtector_PinUnpinRoundTrip(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	var env repotesting.Environment
+	env.Setup(t)
+
+	p := NewManifestRetentionProtector(env.RepositoryWriter)
+
+	retain, _, err := p.ShouldRetain(ctx, "blob1")
+	require.NoError(t, err)
+	require.False(t, retain)
+
+	require.NoError(t, PinBlobForRetention(ctx, env.RepositoryWriter, blob.ID("blob1"), "legal hold"))
+
+	retain, reason, err := p.ShouldRetain(ctx, "blob1")
+	require.NoError(t, err)
+	require.True(t, retain)
+	require.Equal(t, "legal hold", reason)
+
+	// an unrelated blob is unaffected.
+	retain, _, err = p.ShouldRetain(ctx, "blob2")
+	require.NoError(t, err)
+	require.False(t, retain)
+
+	require.NoError(t, UnpinBlobFromRetention(ctx, env.RepositoryWriter, blob.ID("blob1")))
+
+	retain, _, err = p.ShouldRetain(ctx, "blob1")
+	require.NoError(t, err)
+	require.False(t, retain)
+}
+
+func TestManifestRetentionProtector_DefaultReason(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	var env repotesting.Environment
+	env.Setup(t)
+
+	p := NewManifestRetentionProtector(env.RepositoryWriter)
+
+	require.NoError(t, PinBlobForRetention(ctx, env.RepositoryWriter, blob.ID("blob1"), ""))
+
+	retain, reason, err := p.ShouldRetain(ctx, "blob1")
+	require.NoError(t, err)
+	require.True(t, retain)
+	require.Equal(t, "pinned by kopia.retention manifest", reason)
+}
+
+func TestProtectBlob_FirstRetainingProtectorWins(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	protectors := []OrphanProtector{
+		fakeOrphanProtector{retain: false},
+		fakeOrphanProtector{retain: true, reason: "second"},
+		fakeOrphanProtector{retain: true, reason: "third"},
+	}
+
+	reason, err := protectBlob(ctx, protectors, "blob1")
+	require.NoError(t, err)
+	require.Equal(t, "second", reason)
+}
+
+func TestProtectBlob_NoProtectorsRetain(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	protectors := []OrphanProtector{
+		fakeOrphanProtector{retain: false},
+		fakeOrphanProtector{retain: false},
+	}
+
+	reason, err := protectBlob(ctx, protectors, "blob1")
+	require.NoError(t, err)
+	require.Empty(t, reason)
+}
+
+type fakeOrphanProtector struct {
+	retain bool
+	reason string
+}
+
+func (f fakeOrphanProtector) ShouldRetain(ctx context.Context, blobID blob.ID) (bool, string, error) {
+	return f.retain, f.reason, nil
+}
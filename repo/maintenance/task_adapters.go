@@ -0,0 +1,172 @@
+package maintenance
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// This file registers the tasks built into the maintenance package. Each adapter
+// fetches its own Schedule since TaskFunc is invoked generically by the registry
+// rather than threaded a schedule computed once by the caller; task-specific gating
+// logic that used to live inline in runQuickMaintenance/runFullMaintenance now lives
+// in the corresponding adapter instead.
+func init() {
+	RegisterTask(TaskRewriteContentsQuick, TaskSpec{
+		Name:     "rewrite short metadata contents",
+		RunOn:    RunOnQuick,
+		Priority: 10,
+		Func:     quickRewriteContentsTaskFunc,
+	})
+
+	RegisterTask(TaskDeleteOrphanedBlobsQuick, TaskSpec{
+		Name:     "delete orphaned metadata blobs",
+		RunOn:    RunOnQuick,
+		Priority: 20,
+		Func:     quickDeleteOrphanedBlobsTaskFunc,
+	})
+
+	RegisterTask(TaskIndexCompaction, TaskSpec{
+		Name:     "compact indexes",
+		RunOn:    RunOnQuick,
+		Priority: 30,
+		Func:     indexCompactionQuickTaskFunc,
+	})
+
+	RegisterTask(TaskCleanupLogs, TaskSpec{
+		Name:     "clean up logs",
+		RunOn:    RunOnBoth,
+		Priority: 40,
+		Func:     cleanupLogsTaskFunc,
+	})
+
+	RegisterTask(TaskRewriteContentsFull, TaskSpec{
+		Name:     "rewrite contents in short packs",
+		RunOn:    RunOnFull,
+		Priority: 10,
+		Func:     fullRewriteContentsTaskFunc,
+	})
+
+	RegisterTask(TaskDropDeletedContentsFull, TaskSpec{
+		Name:     "drop deleted contents",
+		RunOn:    RunOnFull,
+		Priority: 20,
+		Func:     dropDeletedContentsFullTaskFunc,
+	})
+
+	RegisterTask(TaskDeleteOrphanedBlobsFull, TaskSpec{
+		Name:     "delete unreferenced blobs",
+		RunOn:    RunOnFull,
+		Priority: 30,
+		Func:     fullDeleteOrphanedBlobsTaskFunc,
+	})
+
+	RegisterTask(TaskCleanupEpochManager, TaskSpec{
+		Name:     "clean up superseded epoch index blobs",
+		RunOn:    RunOnFull,
+		Priority: 50,
+		Func:     cleanupEpochManagerTaskFunc,
+	})
+}
+
+func quickRewriteContentsTaskFunc(ctx context.Context, rp RunParameters, safety SafetyParameters) error {
+	s, err := GetSchedule(ctx, rp.rep)
+	if err != nil {
+		return errors.Wrap(err, "unable to get schedule")
+	}
+
+	if !shouldQuickRewriteContents(s, safety) {
+		notRewritingContents(ctx)
+		return nil
+	}
+
+	return runTaskRewriteContentsQuick(ctx, rp, s, safety)
+}
+
+func quickDeleteOrphanedBlobsTaskFunc(ctx context.Context, rp RunParameters, safety SafetyParameters) error {
+	s, err := GetSchedule(ctx, rp.rep)
+	if err != nil {
+		return errors.Wrap(err, "unable to get schedule")
+	}
+
+	if !shouldDeleteOrphanedPacks(rp.rep.Time(), s, safety) {
+		notDeletingOrphanedBlobs(ctx, s, safety)
+		return nil
+	}
+
+	// if the last rewrite was full (started as part of full maintenance) we must complete
+	// it by running full orphaned blob deletion, otherwise the next quick maintenance would
+	// start a quick rewrite and we'd never delete blobs orphaned by the full rewrite.
+	if hadRecentFullRewrite(s) {
+		log(ctx).Debugf("Had recent full rewrite - performing full blob deletion.")
+		return runTaskDeleteOrphanedBlobsFull(ctx, rp, s, safety)
+	}
+
+	log(ctx).Debugf("Performing quick blob deletion.")
+
+	return runTaskDeleteOrphanedBlobsQuick(ctx, rp, s, safety)
+}
+
+func indexCompactionQuickTaskFunc(ctx context.Context, rp RunParameters, safety SafetyParameters) error {
+	s, err := GetSchedule(ctx, rp.rep)
+	if err != nil {
+		return errors.Wrap(err, "unable to get schedule")
+	}
+
+	return runTaskIndexCompactionQuick(ctx, rp, s, safety)
+}
+
+func cleanupLogsTaskFunc(ctx context.Context, rp RunParameters, safety SafetyParameters) error {
+	s, err := GetSchedule(ctx, rp.rep)
+	if err != nil {
+		return errors.Wrap(err, "unable to get schedule")
+	}
+
+	return runTaskCleanupLogs(ctx, rp, s)
+}
+
+func fullRewriteContentsTaskFunc(ctx context.Context, rp RunParameters, safety SafetyParameters) error {
+	s, err := GetSchedule(ctx, rp.rep)
+	if err != nil {
+		return errors.Wrap(err, "unable to get schedule")
+	}
+
+	if !shouldFullRewriteContents(s, safety) {
+		notRewritingContents(ctx)
+		return nil
+	}
+
+	return runTaskRewriteContentsFull(ctx, rp, s, safety)
+}
+
+func dropDeletedContentsFullTaskFunc(ctx context.Context, rp RunParameters, safety SafetyParameters) error {
+	s, err := GetSchedule(ctx, rp.rep)
+	if err != nil {
+		return errors.Wrap(err, "unable to get schedule")
+	}
+
+	return runTaskDropDeletedContentsFull(ctx, rp, s, safety)
+}
+
+func fullDeleteOrphanedBlobsTaskFunc(ctx context.Context, rp RunParameters, safety SafetyParameters) error {
+	s, err := GetSchedule(ctx, rp.rep)
+	if err != nil {
+		return errors.Wrap(err, "unable to get schedule")
+	}
+
+	if !shouldDeleteOrphanedPacks(rp.rep.Time(), s, safety) {
+		notDeletingOrphanedBlobs(ctx, s, safety)
+		return nil
+	}
+
+	return runTaskDeleteOrphanedBlobsFull(ctx, rp, s, safety)
+}
+
+func cleanupEpochManagerTaskFunc(ctx context.Context, rp RunParameters, safety SafetyParameters) error {
+	s, err := GetSchedule(ctx, rp.rep)
+	if err != nil {
+		return errors.Wrap(err, "unable to get schedule")
+	}
+
+	return runTaskCleanupEpochManager(ctx, rp, s)
+}
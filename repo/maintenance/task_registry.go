@@ -0,0 +1,146 @@
+package maintenance
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo/maintenance/metrics"
+)
+
+// RunOn is a bitmask indicating which maintenance cycle(s) a task is eligible to run on.
+type RunOn int
+
+// Supported RunOn values.
+const (
+	RunOnQuick RunOn = 1 << iota
+	RunOnFull
+	RunOnBoth = RunOnQuick | RunOnFull
+)
+
+// RunOptions customizes a single invocation of Run.
+type RunOptions struct {
+	// TaskFilter, when non-empty, restricts Run to exactly the listed tasks instead of
+	// the full set declared eligible for the current mode, ignoring each task's RunOn
+	// and ManualOnly settings. This lets an operator manually trigger a single task
+	// (e.g. "just run snapshot-gc") without waiting for its normal schedule.
+	TaskFilter []TaskType
+
+	// Metrics, when set, receives per-task duration/result and result-specific
+	// counters for this invocation of Run.
+	Metrics *metrics.Registry
+
+	// Progress, when set, receives TaskProgress updates as tasks run. Sends are
+	// non-blocking: an update is dropped rather than stalling maintenance if the
+	// channel is unbuffered or full, so slow consumers must tolerate gaps.
+	Progress chan<- TaskProgress
+
+	// OrphanProtectors are consulted, in order, before deleting any blob that
+	// DeleteUnreferencedBlobs otherwise considers orphaned; any blob a protector
+	// votes to retain is excluded from deletion and logged with its reason.
+	OrphanProtectors []OrphanProtector
+}
+
+// TaskFunc performs the work of a single maintenance task.
+type TaskFunc func(ctx context.Context, rp RunParameters, safety SafetyParameters) error
+
+// TaskSpec describes a task registered with RegisterTask.
+type TaskSpec struct {
+	// Name is a human-readable label used in logs.
+	Name string
+
+	// RunOn determines whether the task is eligible to run as part of the quick
+	// cycle, the full cycle, or both.
+	RunOn RunOn
+
+	// DefaultInterval is informational, documenting how often the task is expected
+	// to do meaningful work; actual scheduling is still driven by each task's own
+	// logic (e.g. consulting Schedule.Runs) since many tasks only act conditionally.
+	DefaultInterval time.Duration
+
+	// Priority controls relative ordering among tasks eligible for the same cycle;
+	// lower values run first. Tasks with equal priority run in registration order.
+	Priority int
+
+	// ManualOnly excludes the task from the regular quick/full cycle; it only runs
+	// when explicitly named in RunOptions.TaskFilter.
+	ManualOnly bool
+
+	// Func is the work performed by the task.
+	Func TaskFunc
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = map[TaskType]TaskSpec{}
+	regOrder   []TaskType
+)
+
+// RegisterTask registers a maintenance task under id, making it eligible to run as
+// part of the quick/full cycle (subject to TaskSpec.RunOn and TaskSpec.ManualOnly)
+// and to be triggered directly through RunOptions.TaskFilter. Packages that implement
+// their own maintenance task (e.g. snapshot/gc registering TaskSnapshotGarbageCollection)
+// should call this from their own init() rather than being invoked by name from here.
+//
+// RegisterTask is not safe to call concurrently with Run; it is
+// intended to be called from init().
+func RegisterTask(id TaskType, spec TaskSpec) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, ok := registry[id]; !ok {
+		regOrder = append(regOrder, id)
+	}
+
+	registry[id] = spec
+}
+
+// tasksFor returns the ordered list of task IDs that should run for the given cycle,
+// honoring an explicit manual filter when provided.
+func tasksFor(runOn RunOn, filter []TaskType) []TaskType {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	var ids []TaskType
+
+	if len(filter) > 0 {
+		ids = append(ids, filter...)
+	} else {
+		for _, id := range regOrder {
+			spec := registry[id]
+			if spec.ManualOnly || spec.RunOn&runOn == 0 {
+				continue
+			}
+
+			ids = append(ids, id)
+		}
+	}
+
+	sort.SliceStable(ids, func(i, j int) bool {
+		return registry[ids[i]].Priority < registry[ids[j]].Priority
+	})
+
+	return ids
+}
+
+// runRegisteredTasks runs every task selected by tasksFor(runOn, filter) in order,
+// stopping (and returning a wrapped error) on the first failure.
+func runRegisteredTasks(ctx context.Context, runParams RunParameters, safety SafetyParameters, runOn RunOn, filter []TaskType) error {
+	for _, id := range tasksFor(runOn, filter) {
+		spec, ok := registry[id]
+		if !ok {
+			return errors.Errorf("unknown maintenance task %q", id)
+		}
+
+		log(ctx).Debugf("running task %v (%v)", id, spec.Name)
+
+		if err := spec.Func(ctx, runParams, safety); err != nil {
+			return errors.Wrapf(err, "error running task %v", spec.Name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package maintenance
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func noopTask(ctx context.Context, rp RunParameters, safety SafetyParameters) error {
+	return nil
+}
+
+func TestTasksFor_HonorsRunOnAndManualOnly(t *testing.T) {
+	const (
+		idQuickOnly  TaskType = "test-quick-only"
+		idFullOnly   TaskType = "test-full-only"
+		idManualOnly TaskType = "test-manual-only"
+	)
+
+	RegisterTask(idQuickOnly, TaskSpec{RunOn: RunOnQuick, Func: noopTask})
+	RegisterTask(idFullOnly, TaskSpec{RunOn: RunOnFull, Func: noopTask})
+	RegisterTask(idManualOnly, TaskSpec{RunOn: RunOnBoth, ManualOnly: true, Func: noopTask})
+
+	quick := tasksFor(RunOnQuick, nil)
+	require.Contains(t, quick, idQuickOnly)
+	require.NotContains(t, quick, idFullOnly)
+	require.NotContains(t, quick, idManualOnly)
+
+	full := tasksFor(RunOnFull, nil)
+	require.Contains(t, full, idFullOnly)
+	require.NotContains(t, full, idQuickOnly)
+	require.NotContains(t, full, idManualOnly)
+}
+
+func TestTasksFor_FilterBypassesRunOnAndManualOnly(t *testing.T) {
+	const idManualOnly TaskType = "test-filter-manual-only"
+
+	RegisterTask(idManualOnly, TaskSpec{RunOn: RunOnQuick, ManualOnly: true, Func: noopTask})
+
+	// idManualOnly is ManualOnly and only eligible for RunOnQuick, yet an explicit
+	// filter must still select it even when run as part of the full cycle.
+	filtered := tasksFor(RunOnFull, []TaskType{idManualOnly})
+	require.Equal(t, []TaskType{idManualOnly}, filtered)
+}
+
+func TestTasksFor_OrdersByPriorityThenRegistration(t *testing.T) {
+	const (
+		idLowPriority  TaskType = "test-priority-low"
+		idHighPriority TaskType = "test-priority-high"
+		idEqualFirst   TaskType = "test-priority-equal-first"
+		idEqualSecond  TaskType = "test-priority-equal-second"
+	)
+
+	// registered out of priority order: the result must still come back sorted.
+	RegisterTask(idHighPriority, TaskSpec{RunOn: RunOnQuick, Priority: 10, Func: noopTask})
+	RegisterTask(idLowPriority, TaskSpec{RunOn: RunOnQuick, Priority: -10, Func: noopTask})
+
+	// equal priority: registration order decides.
+	RegisterTask(idEqualFirst, TaskSpec{RunOn: RunOnQuick, Priority: 5, Func: noopTask})
+	RegisterTask(idEqualSecond, TaskSpec{RunOn: RunOnQuick, Priority: 5, Func: noopTask})
+
+	ids := tasksFor(RunOnQuick, nil)
+
+	require.Less(t, indexOf(ids, idLowPriority), indexOf(ids, idHighPriority))
+	require.Less(t, indexOf(ids, idEqualFirst), indexOf(ids, idEqualSecond))
+}
+
+func TestRunRegisteredTasks_StopsOnFirstFailure(t *testing.T) {
+	const (
+		idFails TaskType = "test-run-fails"
+		idAfter TaskType = "test-run-after-fails"
+	)
+
+	var ranAfter bool
+
+	RegisterTask(idFails, TaskSpec{
+		RunOn:    RunOnQuick,
+		Priority: -100,
+		Func: func(ctx context.Context, rp RunParameters, safety SafetyParameters) error {
+			return errors.New("boom")
+		},
+	})
+	RegisterTask(idAfter, TaskSpec{
+		RunOn:    RunOnQuick,
+		Priority: -99,
+		Func: func(ctx context.Context, rp RunParameters, safety SafetyParameters) error {
+			ranAfter = true
+			return nil
+		},
+	})
+
+	err := runRegisteredTasks(context.Background(), RunParameters{}, SafetyParameters{}, RunOnQuick, []TaskType{idFails, idAfter})
+	require.Error(t, err)
+	require.False(t, ranAfter)
+}
+
+func indexOf(ids []TaskType, id TaskType) int {
+	for i, v := range ids {
+		if v == id {
+			return i
+		}
+	}
+
+	return -1
+}